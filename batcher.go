@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// batchControlMessage is the JSON shape exchanged over a batch viewer's
+// "signal" DataChannel: subscribe/unsubscribe requests from the client, and
+// offer/answer renegotiation in both directions.
+type batchControlMessage struct {
+	Type       string   `json:"type"`
+	StreamList []string `json:"streamList,omitempty"`
+	PageSize   int      `json:"pageSize,omitempty"`
+	PageNum    int      `json:"pageNum,omitempty"`
+	Offer      string   `json:"offer,omitempty"`
+	Answer     string   `json:"answer,omitempty"`
+}
+
+// batchSubscription is one stream a BatchViewer is currently tiled onto.
+type batchSubscription struct {
+	conn    *WebRTCConnection
+	senders []*webrtc.RTPSender
+}
+
+// BatchViewer is a single viewer PeerConnection subscribed to many streams
+// at once (e.g. a moderator dashboard showing a grid of tiles), instead of
+// the one-PeerConnection-per-stream model handleViewer uses. Subscriptions
+// are driven entirely over a "signal" DataChannel after the initial offer/
+// answer exchange, since the set of tracks - and therefore the SDP - changes
+// every time the client subscribes or unsubscribes from a stream.
+type BatchViewer struct {
+	id          string
+	pc          *webrtc.PeerConnection
+	dataChannel *webrtc.DataChannel
+
+	mutex         sync.Mutex
+	subscriptions map[string]*batchSubscription
+
+	answers chan string
+
+	// requests/done back the dedicated worker goroutine that runs
+	// subscribe/unsubscribe (and the renegotiation they trigger); see run.
+	requests chan batchRequest
+	done     chan struct{}
+}
+
+// batchRequest is a subscribe/unsubscribe job handed from handleSignal to
+// the worker goroutine started by run.
+type batchRequest struct {
+	action string // "subscribe" or "unsubscribe"
+	keys   []string
+}
+
+func newBatchViewer(id string, pc *webrtc.PeerConnection) *BatchViewer {
+	return &BatchViewer{
+		id:            id,
+		pc:            pc,
+		subscriptions: make(map[string]*batchSubscription),
+		answers:       make(chan string, 1),
+		requests:      make(chan batchRequest, 16),
+		done:          make(chan struct{}),
+	}
+}
+
+// run processes subscribe/unsubscribe requests one at a time on a goroutine
+// of its own. This has to be off the DataChannel's OnMessage callback: pion
+// invokes OnMessage synchronously from the channel's single read loop, and
+// subscribe/unsubscribe call renegotiate, which blocks waiting for the
+// client's "answer" message - but that answer can only ever be delivered by
+// that same read loop calling OnMessage again, which it can't do while
+// blocked inside this call. Running the work here instead leaves the read
+// loop free to deliver the answer while renegotiate waits for it.
+func (bv *BatchViewer) run() {
+	for {
+		select {
+		case req := <-bv.requests:
+			switch req.action {
+			case "subscribe":
+				bv.subscribe(req.keys)
+			case "unsubscribe":
+				bv.unsubscribe(req.keys)
+			}
+		case <-bv.done:
+			return
+		}
+	}
+}
+
+// enqueue hands a subscribe/unsubscribe request to run, dropping it instead
+// of blocking the DataChannel read loop if the queue is ever full.
+func (bv *BatchViewer) enqueue(action string, keys []string) {
+	select {
+	case bv.requests <- batchRequest{action: action, keys: keys}:
+	default:
+		log.Printf("Batch viewer %s request queue full, dropping %s", bv.id, action)
+	}
+}
+
+// handleBatcher upgrades to a WebSocket just long enough to exchange the
+// initial offer/answer that establishes the PeerConnection and its "signal"
+// DataChannel; every subscribe/unsubscribe/renegotiation after that happens
+// over the DataChannel, not the WebSocket.
+func handleBatcher(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	pc, err := createPeerConnection()
+	if err != nil {
+		log.Printf("Create PC error: %v", err)
+		return
+	}
+	defer pc.Close()
+
+	bv := newBatchViewer(generateViewerID(), pc)
+	log.Printf("New batch viewer connected: %s", bv.id)
+	go bv.run()
+	defer bv.teardown()
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != "signal" {
+			return
+		}
+		bv.dataChannel = dc
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			bv.handleSignal(msg.Data)
+		})
+	})
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Read error from batch viewer %s: %v", bv.id, err)
+			break
+		}
+
+		var message Message
+		if err := json.Unmarshal(msg, &message); err != nil {
+			log.Printf("Parse error from batch viewer %s: %v", bv.id, err)
+			continue
+		}
+
+		if message.Type != "offer" {
+			continue
+		}
+
+		offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: message.SDP}
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			log.Printf("Failed to set remote description for batch viewer %s: %v", bv.id, err)
+			continue
+		}
+
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			log.Printf("Failed to create answer for batch viewer %s: %v", bv.id, err)
+			continue
+		}
+		if err := pc.SetLocalDescription(answer); err != nil {
+			log.Printf("Failed to set local description for batch viewer %s: %v", bv.id, err)
+			continue
+		}
+
+		if err := conn.WriteJSON(Message{Type: "answer", SDP: answer.SDP}); err != nil {
+			log.Printf("Failed to send answer to batch viewer %s: %v", bv.id, err)
+		}
+	}
+}
+
+// handleSignal dispatches one control message received on the "signal"
+// DataChannel.
+func (bv *BatchViewer) handleSignal(data []byte) {
+	var msg batchControlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Batch viewer %s sent unparsable signal: %v", bv.id, err)
+		return
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		bv.enqueue("subscribe", paginateStreamList(msg.StreamList, msg.PageSize, msg.PageNum))
+	case "unsubscribe":
+		bv.enqueue("unsubscribe", msg.StreamList)
+	case "answer":
+		select {
+		case bv.answers <- msg.Answer:
+		default:
+			log.Printf("Batch viewer %s sent an answer with no pending renegotiation", bv.id)
+		}
+	default:
+		log.Printf("Batch viewer %s sent unknown signal type %q", bv.id, msg.Type)
+	}
+}
+
+// paginateStreamList deterministically slices a candidate stream list by
+// page, so a dashboard with more tiles than fit on screen at once can page
+// through them instead of subscribing to everything up front.
+func paginateStreamList(streamList []string, pageSize, pageNum int) []string {
+	if pageSize <= 0 {
+		pageSize = len(streamList)
+	}
+	start := pageNum * pageSize
+	if start >= len(streamList) {
+		return nil
+	}
+	end := start + pageSize
+	if end > len(streamList) {
+		end = len(streamList)
+	}
+	return streamList[start:end]
+}
+
+// subscribe adds a transceiver for each track of each requested stream and,
+// if anything changed, renegotiates over the DataChannel.
+func (bv *BatchViewer) subscribe(keys []string) {
+	bv.mutex.Lock()
+	changed := false
+	for _, key := range keys {
+		if _, ok := bv.subscriptions[key]; ok {
+			continue
+		}
+		if !registry.IsAllowedKey(key) {
+			log.Printf("Batch viewer %s requested disallowed stream key %q", bv.id, key)
+			continue
+		}
+
+		stream := registry.GetOrCreate(key)
+		sub := &batchSubscription{conn: &WebRTCConnection{}}
+		for _, track := range stream.BroadcasterTracks() {
+			sender, err := bv.pc.AddTrack(track)
+			if err != nil {
+				log.Printf("Batch viewer %s failed to add track for stream %s: %v", bv.id, key, err)
+				continue
+			}
+			sub.senders = append(sub.senders, sender)
+		}
+
+		stream.AddViewer(bv.id+":"+key, sub.conn)
+		stream.SendKeyFrameRequest()
+		bv.subscriptions[key] = sub
+		changed = true
+	}
+	bv.mutex.Unlock()
+
+	if changed {
+		bv.renegotiate()
+	}
+}
+
+// unsubscribe removes the tracks for each requested stream and, if anything
+// changed, renegotiates over the DataChannel.
+func (bv *BatchViewer) unsubscribe(keys []string) {
+	bv.mutex.Lock()
+	changed := false
+	for _, key := range keys {
+		sub, ok := bv.subscriptions[key]
+		if !ok {
+			continue
+		}
+		for _, sender := range sub.senders {
+			if err := bv.pc.RemoveTrack(sender); err != nil {
+				log.Printf("Batch viewer %s failed to remove track for stream %s: %v", bv.id, key, err)
+			}
+		}
+		if stream, ok := registry.Get(key); ok {
+			stream.RemoveViewer(bv.id + ":" + key)
+		}
+		delete(bv.subscriptions, key)
+		changed = true
+	}
+	bv.mutex.Unlock()
+
+	if changed {
+		bv.renegotiate()
+	}
+}
+
+// renegotiate creates a fresh offer reflecting the current transceiver set,
+// sends it over the "signal" DataChannel, and blocks until the client's
+// answer arrives (or the wait times out).
+func (bv *BatchViewer) renegotiate() {
+	dc := bv.dataChannel
+	if dc == nil {
+		log.Printf("Batch viewer %s has no signal data channel yet, deferring renegotiation", bv.id)
+		return
+	}
+
+	offer, err := bv.pc.CreateOffer(nil)
+	if err != nil {
+		log.Printf("Batch viewer %s failed to create renegotiation offer: %v", bv.id, err)
+		return
+	}
+	if err := bv.pc.SetLocalDescription(offer); err != nil {
+		log.Printf("Batch viewer %s failed to set local description: %v", bv.id, err)
+		return
+	}
+
+	payload, err := json.Marshal(batchControlMessage{Type: "offer", Offer: offer.SDP})
+	if err != nil {
+		log.Printf("Batch viewer %s failed to marshal renegotiation offer: %v", bv.id, err)
+		return
+	}
+	if err := dc.SendText(string(payload)); err != nil {
+		log.Printf("Batch viewer %s failed to send renegotiation offer: %v", bv.id, err)
+		return
+	}
+
+	select {
+	case answerSDP := <-bv.answers:
+		answer := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answerSDP}
+		if err := bv.pc.SetRemoteDescription(answer); err != nil {
+			log.Printf("Batch viewer %s failed to set remote answer: %v", bv.id, err)
+		}
+	case <-time.After(5 * time.Second):
+		log.Printf("Batch viewer %s timed out waiting for a renegotiation answer", bv.id)
+	}
+}
+
+// teardown removes every remaining subscription when the parent
+// PeerConnection disconnects, so no stream is left thinking this viewer is
+// still watching.
+func (bv *BatchViewer) teardown() {
+	close(bv.done)
+
+	bv.mutex.Lock()
+	defer bv.mutex.Unlock()
+
+	count := len(bv.subscriptions)
+	for key := range bv.subscriptions {
+		if stream, ok := registry.Get(key); ok {
+			stream.RemoveViewer(bv.id + ":" + key)
+		}
+		delete(bv.subscriptions, key)
+	}
+	log.Printf("Batch viewer %s disconnected, tore down %d subscriptions", bv.id, count)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaginateStreamList(t *testing.T) {
+	streams := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		name              string
+		pageSize, pageNum int
+		want              []string
+	}{
+		{"first page", 2, 0, []string{"a", "b"}},
+		{"middle page", 2, 1, []string{"c", "d"}},
+		{"last partial page", 2, 2, []string{"e"}},
+		{"past the end", 2, 3, nil},
+		{"zero page size returns everything", 0, 0, streams},
+		{"negative page size returns everything", -1, 0, streams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paginateStreamList(streams, tt.pageSize, tt.pageNum)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paginateStreamList(%v, %d, %d) = %v, want %v", streams, tt.pageSize, tt.pageNum, got, tt.want)
+			}
+		})
+	}
+}
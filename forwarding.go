@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// attachBroadcasterTrack handles one remote track from a broadcaster's
+// pc.OnTrack callback: a simulcast layer (non-empty RID) is registered on
+// the stream and read straight off the wire, while a regular track gets a
+// local TrackLocalStaticRTP that fans out to every viewer PeerConnection
+// subscribed to it, and to the stream's Recorder if one is running. Shared
+// by the WebSocket (handleBroadcaster) and WHIP (handleWHIP) ingestion
+// paths so a future change to track handling only has to be made once.
+func attachBroadcasterTrack(stream *Stream, b *WebRTCConnection, remoteTrack *webrtc.TrackRemote) {
+	log.Printf("Got remote track on stream %s: %v, kind: %v", stream.Key, remoteTrack.ID(), remoteTrack.Kind())
+
+	// A non-empty RID means this is one layer of a simulcast publish
+	// (multiple RIDs on the same MID); keep each layer as its own
+	// SimulcastLayer instead of folding it into a single local track, so
+	// viewers can be forwarded whichever layer fits their bandwidth.
+	if rid := remoteTrack.RID(); rid != "" {
+		layer := newSimulcastLayer(rid, uint32(remoteTrack.SSRC()), remoteTrack.Codec().RTPCodecCapability)
+		stream.SetVideoLayer(rid, layer)
+		log.Printf("Registered simulcast layer %q for stream %s", rid, stream.Key)
+
+		go func() {
+			for {
+				packet, _, err := remoteTrack.ReadRTP()
+				if err != nil {
+					log.Printf("Failed to read RTP packet for layer %s: %v", rid, err)
+					return
+				}
+				layer.publish(packet)
+			}
+		}()
+		return
+	}
+
+	// Create a local track per remote track, preserving its ID/stream ID so
+	// audio and video (and multiple video tracks) all forward independently
+	// instead of colliding on a single "audio" track.
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(
+		remoteTrack.Codec().RTPCodecCapability,
+		remoteTrack.ID(),
+		remoteTrack.StreamID(),
+	)
+	if err != nil {
+		log.Printf("Failed to create local track: %v", err)
+		return
+	}
+	b.AddTrack(localTrack, remoteTrackInfo{SSRC: uint32(remoteTrack.SSRC()), Kind: remoteTrack.Kind()})
+	log.Printf("Created local track for forwarding: %v", localTrack.ID())
+
+	// Forward RTP packets from broadcaster to all of this stream's viewers.
+	// localTrack is shared across every viewer PeerConnection that calls
+	// AddTrack on it, so a single WriteRTP fans out to all of them - no
+	// need to iterate the viewer set here.
+	trackID := localTrack.ID()
+	kind := remoteTrack.Kind()
+	mimeType := remoteTrack.Codec().RTPCodecCapability.MimeType
+	go func() {
+		for {
+			packet, _, err := remoteTrack.ReadRTP()
+			if err != nil {
+				log.Printf("Failed to read RTP packet: %v", err)
+				return
+			}
+			if err := localTrack.WriteRTP(packet); err != nil {
+				log.Printf("Failed to write RTP for stream %s: %v", stream.Key, err)
+			}
+			if rec := stream.ActiveRecorder(); rec != nil {
+				rec.WritePacket(trackID, kind, mimeType, packet)
+			}
+		}
+	}()
+}
+
+// subscribeViewerToStream adds the stream's current broadcaster tracks to
+// pc, subscribes to the best available simulcast video layer if the
+// broadcaster is publishing simulcast, and asks the broadcaster for a fresh
+// keyframe so the viewer isn't stuck looking at a black screen until the
+// next natural IDR. Shared by the WebSocket (handleViewer) and WHEP
+// (handleWHEP) egress paths. Returns the LayerForwarder so the caller can
+// adapt or close it, or nil if the broadcaster isn't publishing simulcast.
+func subscribeViewerToStream(pc *webrtc.PeerConnection, stream *Stream, viewerID string) *LayerForwarder {
+	if tracks := stream.BroadcasterTracks(); tracks != nil {
+		log.Printf("Adding %d tracks from broadcaster to viewer %s", len(tracks), viewerID)
+
+		for _, track := range tracks {
+			rtpSender, err := pc.AddTrack(track)
+			if err != nil {
+				log.Printf("Failed to add track to viewer %s: %v", viewerID, err)
+				continue
+			}
+			log.Printf("Added track %s to viewer %s", track.ID(), viewerID)
+
+			go func() {
+				rtcpBuf := make([]byte, 1500)
+				for {
+					if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
+						return
+					}
+				}
+			}()
+		}
+	} else {
+		log.Printf("No broadcaster present for viewer %s", viewerID)
+	}
+
+	// Subscribe to the broadcaster's simulcast video, if any, starting at
+	// the best available layer. monitorViewerBandwidth then adapts the
+	// layer automatically as the estimate moves.
+	var forwarder *LayerForwarder
+	if best := stream.BestVideoLayer(); best != nil {
+		localTrack, err := webrtc.NewTrackLocalStaticRTP(best.Codec, "simulcast-video", "video-"+viewerID)
+		if err != nil {
+			log.Printf("Failed to create simulcast local track for viewer %s: %v", viewerID, err)
+		} else if rtpSender, err := pc.AddTrack(localTrack); err != nil {
+			log.Printf("Failed to add simulcast track to viewer %s: %v", viewerID, err)
+		} else {
+			forwarder = newLayerForwarder(localTrack)
+			forwarder.switchTo(best)
+			log.Printf("Viewer %s subscribed to simulcast layer %q", viewerID, best.RID)
+			go monitorViewerBandwidth(rtpSender, stream, forwarder, viewerID)
+		}
+	}
+
+	stream.SendKeyFrameRequest()
+	return forwarder
+}
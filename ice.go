@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// turnCredentialTTL bounds how long a REST-signed TURN credential is valid
+// for. Six hours comfortably outlasts any single viewing session while
+// still rotating often enough that a leaked credential doesn't work
+// forever.
+const turnCredentialTTL = 6 * time.Hour
+
+// ICEServerSpec is one entry in the configured ICE server pool: a STUN
+// server (no credentials), a TURN server with a static username/credential,
+// or a TURN server using the REST auth scheme, whose username/credential
+// are signed fresh on every use by restTURNCredential.
+type ICEServerSpec struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+	REST       bool     `json:"-"`
+}
+
+// iceServerSpecs is the process-wide ICE server pool, loaded once in
+// main() from config, same pattern as registry and recorderConfig.
+var iceServerSpecs []ICEServerSpec
+
+// loadICEServers resolves the configured ICE server pool: a JSON config
+// file (ICE_CONFIG_FILE) if set, else the comma-separated ICE_SERVERS env
+// var, else a single STUN server, so existing single-STUN deployments keep
+// working unchanged.
+func loadICEServers(stunFallback string) []ICEServerSpec {
+	if path := os.Getenv("ICE_CONFIG_FILE"); path != "" {
+		specs, err := loadICEServersFromFile(path)
+		if err != nil {
+			log.Printf("Failed to load ICE_CONFIG_FILE %s: %v", path, err)
+		} else {
+			return specs
+		}
+	}
+
+	if raw := os.Getenv("ICE_SERVERS"); raw != "" {
+		return parseICEServersEnv(raw)
+	}
+
+	return []ICEServerSpec{{URLs: []string{stunFallback}}}
+}
+
+func loadICEServersFromFile(path string) ([]ICEServerSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []ICEServerSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, err
+	}
+	for i := range specs {
+		specs[i].REST = specs[i].Username == "" && specs[i].Credential == "" && isTURNURLs(specs[i].URLs)
+	}
+	return specs, nil
+}
+
+// parseICEServersEnv parses ICE_SERVERS as a comma-separated list of
+// entries, each either a bare URL ("stun:..." or "turn:...") or
+// "url|username|credential" for a TURN server with a static credential. A
+// bare TURN URL is always marked for the REST auth scheme, whether or not
+// TURN_SECRET is set; buildICEServers only signs credentials for it when
+// TURN_SECRET is set at that point.
+func parseICEServersEnv(raw string) []ICEServerSpec {
+	var specs []ICEServerSpec
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, "|")
+		spec := ICEServerSpec{URLs: []string{parts[0]}}
+		if len(parts) >= 3 {
+			spec.Username = parts[1]
+			spec.Credential = parts[2]
+		} else {
+			spec.REST = isTURNURLs(spec.URLs)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func isTURNURLs(urls []string) bool {
+	for _, u := range urls {
+		if strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:") {
+			return true
+		}
+	}
+	return false
+}
+
+// restTURNCredential signs a fresh time-limited TURN credential per the
+// "REST API for Access to TURN Services" scheme: username is
+// "<expiry-unix>:<userID>", credential is the base64-encoded HMAC-SHA1 of
+// that username keyed by TURN_SECRET. ok is false if TURN_SECRET isn't
+// configured, in which case the caller should leave the server's static
+// (or absent) credentials alone.
+func restTURNCredential(userID string, ttl time.Duration) (username, credential string, ok bool) {
+	secret := os.Getenv("TURN_SECRET")
+	if secret == "" {
+		return "", "", false
+	}
+
+	expiry := time.Now().Add(ttl).Unix()
+	username = fmt.Sprintf("%d:%s", expiry, userID)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, credential, true
+}
+
+// buildICEServers resolves the configured ICE server pool into
+// webrtc.ICEServer values, signing a fresh REST TURN credential for any
+// server that needs one. Call this per-PeerConnection (and per
+// /ice-servers request) so TURN credentials are always within their TTL.
+func buildICEServers(userID string) []webrtc.ICEServer {
+	servers := make([]webrtc.ICEServer, 0, len(iceServerSpecs))
+	for _, spec := range iceServerSpecs {
+		server := webrtc.ICEServer{URLs: spec.URLs, Username: spec.Username, Credential: spec.Credential}
+		if spec.REST {
+			if username, credential, ok := restTURNCredential(userID, turnCredentialTTL); ok {
+				server.Username = username
+				server.Credential = credential
+			} else {
+				log.Printf("TURN server %v configured for REST auth but TURN_SECRET is unset; skipping credentials", spec.URLs)
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// handleICEServers returns freshly signed ICE server credentials for a
+// browser client to populate RTCPeerConnection's iceServers with, so TURN
+// credentials aren't baked into a page load and expire on their own
+// schedule.
+func handleICEServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	servers := buildICEServers(generateViewerID())
+
+	type iceServerJSON struct {
+		URLs       []string `json:"urls"`
+		Username   string   `json:"username,omitempty"`
+		Credential string   `json:"credential,omitempty"`
+	}
+	out := make([]iceServerJSON, len(servers))
+	for i, s := range servers {
+		credential, _ := s.Credential.(string)
+		out[i] = iceServerJSON{URLs: s.URLs, Username: s.Username, Credential: credential}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"iceServers": out})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICEServersEnv(t *testing.T) {
+	t.Setenv("TURN_SECRET", "")
+
+	got := parseICEServersEnv("stun:stun.example.com:3478, turn:turn.example.com:3478, turn:static.example.com|alice|s3cret")
+	want := []ICEServerSpec{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:turn.example.com:3478"}, REST: true},
+		{URLs: []string{"turn:static.example.com"}, Username: "alice", Credential: "s3cret"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseICEServersEnv() = %#v, want %#v", got, want)
+	}
+}
+
+func TestParseICEServersEnvSkipsEmptyEntries(t *testing.T) {
+	got := parseICEServersEnv("stun:a.example.com,,  ,stun:b.example.com")
+	if len(got) != 2 {
+		t.Fatalf("parseICEServersEnv() returned %d specs, want 2: %#v", len(got), got)
+	}
+}
+
+func TestRestTURNCredentialNoSecret(t *testing.T) {
+	t.Setenv("TURN_SECRET", "")
+
+	_, _, ok := restTURNCredential("viewer-1", time.Hour)
+	if ok {
+		t.Error("restTURNCredential() ok = true with no TURN_SECRET set, want false")
+	}
+}
+
+func TestRestTURNCredentialSignsHMAC(t *testing.T) {
+	t.Setenv("TURN_SECRET", "sekrit")
+
+	username, credential, ok := restTURNCredential("viewer-1", time.Hour)
+	if !ok {
+		t.Fatal("restTURNCredential() ok = false with TURN_SECRET set, want true")
+	}
+	if !strings.HasSuffix(username, ":viewer-1") {
+		t.Errorf("username = %q, want suffix %q", username, ":viewer-1")
+	}
+
+	mac := hmac.New(sha1.New, []byte("sekrit"))
+	mac.Write([]byte(username))
+	want := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if credential != want {
+		t.Errorf("credential = %q, want %q (HMAC-SHA1 of username keyed by TURN_SECRET)", credential, want)
+	}
+}
@@ -5,56 +5,96 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"github.com/pion/webrtc/v3"
 )
 
-// WebRTC and WebSocket configurations
-var (
-	upgrader = websocket.Upgrader{
-		CheckOrigin:      func(r *http.Request) bool { return true },
-		ReadBufferSize:   1024,
-		WriteBufferSize:  1024,
-	}
-
-	webrtcConfig = webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	}
-)
+// WebSocket configuration. ICE server configuration lives in
+// iceServerSpecs (see ice.go) since, unlike this, it varies per
+// PeerConnection (fresh TURN REST credentials on every call).
+var upgrader = websocket.Upgrader{
+	CheckOrigin:     func(r *http.Request) bool { return true },
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
 
-// Global state management
-var (
-	broadcaster   *WebRTCConnection
-	viewers       = make(map[string]*WebRTCConnection)
-	viewersMutex  sync.RWMutex
-)
+// Global state management. registry replaces the old single broadcaster/
+// viewers globals so the server can host multiple concurrent streams, each
+// keyed by its own stream key; see stream_registry.go.
+var registry *StreamRegistry
 
 // Types
 type WebRTCConnection struct {
 	PeerConnection *webrtc.PeerConnection
 	WebSocket      *websocket.Conn
-	StreamTracks   []*webrtc.TrackLocalStaticRTP
+
+	// mutex guards StreamTracks/TrackInfo below, which pc.OnTrack mutates
+	// once per remote track - concurrently, since pion fires it on its own
+	// goroutine per track, and a broadcaster now commonly publishes more
+	// than one (audio + video).
+	mutex        sync.Mutex
+	StreamTracks []*webrtc.TrackLocalStaticRTP
+	// TrackInfo carries the SSRC/kind of each broadcaster remote track,
+	// keyed the same as StreamTracks by track ID, so callers can target a
+	// PLI at the right SSRC without holding onto the *webrtc.TrackRemote.
+	TrackInfo map[string]remoteTrackInfo
+}
+
+// AddTrack records a newly forwarded remote track, safe to call from
+// multiple pc.OnTrack invocations concurrently.
+func (c *WebRTCConnection) AddTrack(track *webrtc.TrackLocalStaticRTP, info remoteTrackInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.StreamTracks = append(c.StreamTracks, track)
+	if c.TrackInfo == nil {
+		c.TrackInfo = make(map[string]remoteTrackInfo)
+	}
+	c.TrackInfo[track.ID()] = info
+}
+
+// Tracks returns a snapshot of the connection's forwarded tracks.
+func (c *WebRTCConnection) Tracks() []*webrtc.TrackLocalStaticRTP {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*webrtc.TrackLocalStaticRTP(nil), c.StreamTracks...)
+}
+
+// TrackInfos returns a snapshot of the connection's remoteTrackInfo map.
+func (c *WebRTCConnection) TrackInfos() map[string]remoteTrackInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	infos := make(map[string]remoteTrackInfo, len(c.TrackInfo))
+	for id, info := range c.TrackInfo {
+		infos[id] = info
+	}
+	return infos
+}
+
+type remoteTrackInfo struct {
+	SSRC uint32
+	Kind webrtc.RTPCodecType
 }
 
 type Message struct {
 	Type      string `json:"type"`
 	SDP       string `json:"sdp,omitempty"`
 	StreamKey string `json:"streamKey,omitempty"`
+	RID       string `json:"rid,omitempty"` // simulcast layer requested by "selectLayer"
 }
 
 // Server configuration
 type ServerConfig struct {
-	Port       string
-	Host       string
-	StunServer string
-	StreamKey  string
+	Port             string
+	Host             string
+	StunServer       string
+	StreamKey        string
+	AllowedStreamKeys []string
 }
 
 func loadConfig() (*ServerConfig, error) {
@@ -62,11 +102,14 @@ func loadConfig() (*ServerConfig, error) {
 		log.Printf("Warning: .env file not found")
 	}
 
+	streamKey := getEnvOrDefault("STREAM_KEY", "your-secret-stream-key")
+
 	return &ServerConfig{
-		Port:       getEnvOrDefault("WEBRTC_PORT", "8080"),
-		Host:       getEnvOrDefault("SERVER_HOST", "localhost"),
-		StunServer: getEnvOrDefault("STUN_SERVER", "stun:stun.l.google.com:19302"),
-		StreamKey:  getEnvOrDefault("STREAM_KEY", "your-secret-stream-key"),
+		Port:              getEnvOrDefault("WEBRTC_PORT", "8080"),
+		Host:              getEnvOrDefault("SERVER_HOST", "localhost"),
+		StunServer:        getEnvOrDefault("STUN_SERVER", "stun:stun.l.google.com:19302"),
+		StreamKey:         streamKey,
+		AllowedStreamKeys: getAllowedStreamKeys(streamKey),
 	}, nil
 }
 
@@ -77,6 +120,28 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getAllowedStreamKeys reads the comma-separated STREAM_KEYS env var (for
+// multi-tenant deployments) and falls back to the single STREAM_KEY so
+// existing single-stream setups keep working unchanged.
+func getAllowedStreamKeys(fallback string) []string {
+	raw := os.Getenv("STREAM_KEYS")
+	if raw == "" {
+		return []string{fallback}
+	}
+
+	var keys []string
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return []string{fallback}
+	}
+	return keys
+}
+
 // Middleware
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -103,6 +168,24 @@ func setupRoutes(mux *http.ServeMux) {
 	// WebSocket endpoints
 	mux.HandleFunc("/broadcast", logRequest(handleBroadcaster))
 	mux.HandleFunc("/view", logRequest(handleViewer))
+	mux.HandleFunc("/batch", logRequest(handleBatcher))
+
+	// WHIP/WHEP endpoints (standards-compliant alternative to the above)
+	mux.HandleFunc("/whip", logRequest(handleWHIP))
+	mux.HandleFunc("/whip/", logRequest(handleWHIPResource))
+	mux.HandleFunc("/whep", logRequest(handleWHEP))
+	mux.HandleFunc("/whep/", logRequest(handleWHEPResource))
+
+	// Stream discovery
+	mux.HandleFunc("/streams", logRequest(handleStreams))
+
+	// Ephemeral ICE/TURN credentials (ice.go)
+	mux.HandleFunc("/ice-servers", logRequest(handleICEServers))
+
+	// Recording/HLS egress (recorder.go). Serves whatever's in RECORD_DIR
+	// regardless of RECORD_ENABLED, so a stream recorded in the past stays
+	// reachable even after recording is turned off.
+	mux.Handle("/hls/", http.StripPrefix("/hls/", http.FileServer(http.Dir(recorderConfig.Dir))))
 
 	// Web routes
 	mux.HandleFunc("/", handleRoot)
@@ -132,9 +215,27 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 func handleBroadcastStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	
+
+	liveCount := 0
+	for _, s := range registry.List() {
+		if s.Live {
+			liveCount++
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"broadcasting": liveCount > 0,
+	})
+}
+
+// handleStreams lists all known streams and their viewer counts, so a
+// dashboard or viewer picker can see what's live without guessing keys.
+func handleStreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"broadcasting": broadcaster != nil,
+		"streams": registry.List(),
 	})
 }
 
@@ -145,6 +246,10 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
+	registry = newStreamRegistry(config.StreamKey, config.AllowedStreamKeys)
+	recorderConfig = loadRecorderConfig()
+	iceServerSpecs = loadICEServers(config.StunServer)
+
 	// Create router and setup routes
 	mux := http.NewServeMux()
 	setupRoutes(mux)
@@ -163,6 +268,7 @@ func main() {
 	log.Printf("Port: %s", config.Port)
 	log.Printf("Host: %s", config.Host)
 	log.Printf("STUN Server: %s", config.StunServer)
+	log.Printf("Recording enabled: %v", recorderConfig.Enabled)
 
 	// Start server
 	log.Printf("Server listening on port %s", config.Port)
@@ -171,11 +277,18 @@ func main() {
 
 // Helper functions
 func createPeerConnection() (*webrtc.PeerConnection, error) {
-	return webrtc.NewPeerConnection(webrtcConfig)
+	config := webrtc.Configuration{ICEServers: buildICEServers(generateViewerID())}
+	return webrtcAPI.NewPeerConnection(config)
 }
 
+// viewerIDCounter hands out a unique suffix per call so concurrent viewers
+// (plain WebSocket, WHEP, and batched dashboard subscriptions) never collide
+// on the same ID - a collision would make AddViewer/RemoveViewer and
+// BatchViewer's subscription map silently clobber another viewer's entry.
+var viewerIDCounter int64
+
 func generateViewerID() string {
-	return "viewer-" + string(os.Getpid())
+	return "viewer-" + strconv.FormatInt(atomic.AddInt64(&viewerIDCounter, 1), 10)
 }
 
 // Add the WebSocket handler functions here
@@ -201,46 +314,21 @@ func handleBroadcaster(w http.ResponseWriter, r *http.Request) {
 		WebSocket:      conn,
 	}
 
-	// Set broadcaster
-	broadcaster = b
+	// streamPtr is resolved once the broadcaster's offer tells us which
+	// stream key it's publishing under; OnTrack only fires after that
+	// point, but pion invokes OnTrack's callback on its own goroutine while
+	// the "offer" message is handled on this one, so a plain *Stream var
+	// would be a data race.
+	var streamPtr atomic.Pointer[Stream]
 
 	// Handle incoming tracks
 	pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		log.Printf("Got remote track from broadcaster: %v, kind: %v", remoteTrack.ID(), remoteTrack.Kind())
-		
-		// Create a local track to forward to viewers
-		localTrack, err := webrtc.NewTrackLocalStaticRTP(
-			remoteTrack.Codec().RTPCodecCapability,
-			"audio", // Fixed ID for audio track
-			"audio", // Fixed stream ID
-		)
-		if err != nil {
-			log.Printf("Failed to create local track: %v", err)
+		stream := streamPtr.Load()
+		if stream == nil {
+			log.Printf("Got remote track before stream key was validated, dropping")
 			return
 		}
-		b.StreamTracks = append(b.StreamTracks, localTrack)
-		log.Printf("Created local track for forwarding: %v", localTrack.ID())
-
-		// Forward RTP packets from broadcaster to all viewers
-		go func() {
-			for {
-				packet, _, err := remoteTrack.ReadRTP()
-				if err != nil {
-					log.Printf("Failed to read RTP packet: %v", err)
-					return
-				}
-
-				viewersMutex.RLock()
-				for id, viewer := range viewers {
-					if len(viewer.StreamTracks) > 0 {
-						if err := viewer.StreamTracks[0].WriteRTP(packet); err != nil {
-							log.Printf("Failed to write RTP to viewer %s: %v", id, err)
-						}
-					}
-				}
-				viewersMutex.RUnlock()
-			}
-		}()
+		attachBroadcasterTrack(stream, b, remoteTrack)
 	})
 
 	// Handle incoming messages
@@ -259,13 +347,16 @@ func handleBroadcaster(w http.ResponseWriter, r *http.Request) {
 
 		switch message.Type {
 		case "offer":
-			// Verify stream key with better logging
-			expectedKey := os.Getenv("STREAM_KEY")
-			if message.StreamKey != expectedKey {
-				log.Printf("Invalid stream key. Expected: %s, Got: %s", expectedKey, message.StreamKey)
+			// Verify stream key against the configured allow-list
+			if !registry.IsAllowedKey(message.StreamKey) {
+				log.Printf("Invalid stream key: %s", message.StreamKey)
 				return
 			}
 
+			stream := registry.GetOrCreate(message.StreamKey)
+			stream.SetBroadcaster(b)
+			streamPtr.Store(stream)
+
 			// Set remote description
 			err = pc.SetRemoteDescription(webrtc.SessionDescription{
 				Type: webrtc.SDPTypeOffer,
@@ -302,7 +393,9 @@ func handleBroadcaster(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Clean up
-	broadcaster = nil
+	if stream := streamPtr.Load(); stream != nil {
+		stream.ClearBroadcaster(b)
+	}
 }
 
 func handleViewer(w http.ResponseWriter, r *http.Request) {
@@ -320,9 +413,21 @@ func handleViewer(w http.ResponseWriter, r *http.Request) {
 	}
 	defer pc.Close()
 
+	// Resolve which stream this viewer wants; default to the single-stream
+	// key for back-compat with clients that don't pass ?stream=<key>
+	streamKey := r.URL.Query().Get("stream")
+	if streamKey == "" {
+		streamKey = registry.DefaultKey()
+	}
+	if !registry.IsAllowedKey(streamKey) {
+		log.Printf("Viewer requested disallowed stream key %q", streamKey)
+		return
+	}
+	stream := registry.GetOrCreate(streamKey)
+
 	// Generate viewer ID
 	viewerID := generateViewerID()
-	log.Printf("New viewer connected: %s", viewerID)
+	log.Printf("New viewer connected: %s on stream %s", viewerID, streamKey)
 
 	// Create viewer connection
 	v := &WebRTCConnection{
@@ -330,45 +435,22 @@ func handleViewer(w http.ResponseWriter, r *http.Request) {
 		WebSocket:      conn,
 	}
 
-	// Add viewer to the map BEFORE processing offer
-	viewersMutex.Lock()
-	viewers[viewerID] = v
-	viewersMutex.Unlock()
+	// Add viewer to the stream BEFORE processing offer
+	stream.AddViewer(viewerID, v)
 
 	defer func() {
-		viewersMutex.Lock()
-		delete(viewers, viewerID)
-		
-		viewersMutex.Unlock()
+		stream.RemoveViewer(viewerID)
 		log.Printf("Viewer disconnected: %s", viewerID)
 	}()
 
-	// Add broadcaster tracks to viewer if broadcaster exists
-	if broadcaster != nil {
-		log.Printf("Adding %d tracks from broadcaster to viewer %s", 
-			len(broadcaster.StreamTracks), viewerID)
-		
-		for _, track := range broadcaster.StreamTracks {
-			rtpSender, err := pc.AddTrack(track)
-			if err != nil {
-				log.Printf("Failed to add track to viewer %s: %v", viewerID, err)
-				continue
-			}
-			log.Printf("Added track %s to viewer %s", track.ID(), viewerID)
-
-			// Handle RTP packets
-			go func() {
-				rtcpBuf := make([]byte, 1500)
-				for {
-					if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-						return
-					}
-				}
-			}()
+	// Add broadcaster tracks (and a simulcast subscription, if any) to the
+	// viewer; the "selectLayer" message below can then retarget it.
+	forwarder := subscribeViewerToStream(pc, stream, viewerID)
+	defer func() {
+		if forwarder != nil {
+			forwarder.close()
 		}
-	} else {
-		log.Printf("No broadcaster present for viewer %s", viewerID)
-	}
+	}()
 
 	// Handle incoming messages
 	for {
@@ -418,6 +500,19 @@ func handleViewer(w http.ResponseWriter, r *http.Request) {
 				log.Printf("Failed to send answer to viewer %s: %v", viewerID, err)
 			}
 			log.Printf("Sent answer to viewer %s", viewerID)
+
+		case "selectLayer":
+			if forwarder == nil {
+				log.Printf("selectLayer from viewer %s but no simulcast subscription is active", viewerID)
+				continue
+			}
+			layer, ok := stream.VideoLayer(message.RID)
+			if !ok {
+				log.Printf("Viewer %s requested unknown simulcast layer %q", viewerID, message.RID)
+				continue
+			}
+			forwarder.switchTo(layer)
+			log.Printf("Viewer %s switched to simulcast layer %q", viewerID, message.RID)
 		}
 	}
 } 
\ No newline at end of file
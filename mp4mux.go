@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Minimal ISO-BMFF (MP4) box writer used by Recorder to produce
+// self-initializing fragmented MP4 segments (ftyp+moov+moof+mdat in a
+// single file) without pulling in a full muxing library.
+
+func mp4Box(boxType string, payload []byte) []byte {
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], boxType)
+	return append(buf, payload...)
+}
+
+func mp4FullBox(boxType string, version byte, flags uint32, payload []byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append(header, payload...))
+}
+
+func concatBoxes(boxes ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, b := range boxes {
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func identityMatrix() []byte {
+	var buf []byte
+	for _, v := range [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		buf = append(buf, u32(v)...)
+	}
+	return buf
+}
+
+// muxSegment writes one self-contained MP4 segment (ftyp, moov, moof, mdat)
+// covering every track's samples accumulated since the last segment.
+func muxSegment(path string, tracks []*recorderTrack, seq uint32, duration time.Duration) error {
+	ftyp := mp4Box("ftyp", concatBoxes([]byte("isom"), u32(1), []byte("isomiso5dash")))
+	moov := moovBox(tracks)
+	moof, mdat := moofMdat(tracks, seq, duration)
+	return os.WriteFile(path, concatBoxes(ftyp, moov, moof, mdat), 0o644)
+}
+
+func moovBox(tracks []*recorderTrack) []byte {
+	mvhd := mp4FullBox("mvhd", 0, 0, concatBoxes(
+		make([]byte, 4), make([]byte, 4), // creation/modification time
+		u32(1000),       // timescale
+		make([]byte, 4), // duration (0: fragmented, length is in the playlist)
+		u32(0x00010000),    // rate 1.0
+		u16(0x0100), u16(0), // volume 1.0, reserved
+		make([]byte, 8), // reserved
+		identityMatrix(),
+		make([]byte, 24), // pre_defined
+		u32(uint32(len(tracks)+1)), // next_track_ID
+	))
+
+	boxes := [][]byte{mvhd}
+	var trexs [][]byte
+	for i, rt := range tracks {
+		trackID := uint32(i + 1)
+		boxes = append(boxes, trakBox(trackID, rt))
+		trexs = append(trexs, mp4FullBox("trex", 0, 0, concatBoxes(u32(trackID), u32(1), u32(0), u32(0), u32(0))))
+	}
+	boxes = append(boxes, mp4Box("mvex", concatBoxes(trexs...)))
+
+	return mp4Box("moov", concatBoxes(boxes...))
+}
+
+func trakBox(trackID uint32, rt *recorderTrack) []byte {
+	isVideo := rt.kind == webrtc.RTPCodecTypeVideo
+
+	volume := uint16(0)
+	if !isVideo {
+		volume = 0x0100
+	}
+	width, height := uint32(0), uint32(0)
+	if isVideo {
+		width, height = 1280<<16, 720<<16 // placeholder; real dimensions need SPS/VPx header parsing
+	}
+
+	tkhd := mp4FullBox("tkhd", 0, 0x7, concatBoxes(
+		make([]byte, 4), make([]byte, 4),
+		u32(trackID),
+		make([]byte, 4),
+		make([]byte, 4), // duration
+		make([]byte, 8), // reserved
+		u16(0), u16(0), // layer, alternate_group
+		u16(volume), u16(0),
+		identityMatrix(),
+		u32(width), u32(height),
+	))
+
+	mdhd := mp4FullBox("mdhd", 0, 0, concatBoxes(
+		make([]byte, 4), make([]byte, 4),
+		u32(trackTimescale(rt)),
+		make([]byte, 4),
+		u16(0x55c4), u16(0), // language "und", pre_defined
+	))
+
+	hdlrType, hdlrName := "soun", "SoundHandler\x00"
+	if isVideo {
+		hdlrType, hdlrName = "vide", "VideoHandler\x00"
+	}
+	hdlr := mp4FullBox("hdlr", 0, 0, concatBoxes(make([]byte, 4), []byte(hdlrType), make([]byte, 12), []byte(hdlrName)))
+
+	var mediaHeader []byte
+	if isVideo {
+		mediaHeader = mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	} else {
+		mediaHeader = mp4FullBox("smhd", 0, 0, make([]byte, 4))
+	}
+
+	dref := mp4FullBox("dref", 0, 0, concatBoxes(u32(1), mp4FullBox("url ", 0, 1, nil)))
+	dinf := mp4Box("dinf", dref)
+
+	stbl := mp4Box("stbl", concatBoxes(
+		stsdBox(rt),
+		mp4FullBox("stts", 0, 0, u32(0)),
+		mp4FullBox("stsc", 0, 0, u32(0)),
+		mp4FullBox("stsz", 0, 0, concatBoxes(u32(0), u32(0))),
+		mp4FullBox("stco", 0, 0, u32(0)),
+	))
+
+	minf := mp4Box("minf", concatBoxes(mediaHeader, dinf, stbl))
+	mdia := mp4Box("mdia", concatBoxes(mdhd, hdlr, minf))
+
+	return mp4Box("trak", concatBoxes(tkhd, mdia))
+}
+
+func trackTimescale(rt *recorderTrack) uint32 {
+	if rt.kind == webrtc.RTPCodecTypeAudio {
+		return 48000
+	}
+	return 90000
+}
+
+// stsdBox builds the track's sample description. H264 gets a real avcC
+// built from the SPS/PPS NALs in its first sample; every other codec gets
+// a minimal, spec-shaped sample entry without a full codec-specific config
+// box, per the scope note on Recorder.
+func stsdBox(rt *recorderTrack) []byte {
+	var entry []byte
+	switch rt.mimeType {
+	case "video/H264":
+		entry = avc1Entry(rt)
+	case "audio/opus":
+		entry = opusEntry()
+	default:
+		log.Printf("Recorder: %s sample entry has no codec-specific config yet; muxed output may not play back", rt.mimeType)
+		entry = genericVideoEntry(rt.mimeType)
+	}
+	return mp4FullBox("stsd", 0, 0, concatBoxes(u32(1), entry))
+}
+
+func visualSampleEntryBody(codecConfig []byte) []byte {
+	return concatBoxes(
+		make([]byte, 6), u16(1), // reserved, data_reference_index
+		make([]byte, 16), // pre_defined/reserved
+		u16(1280), u16(720),
+		u32(0x00480000), u32(0x00480000), // horiz/vertresolution, 72dpi
+		u32(0), u16(1), // reserved, frame_count
+		make([]byte, 32), // compressorname
+		u16(0x0018), u16(0xffff), // depth, pre_defined
+		codecConfig,
+	)
+}
+
+func avc1Entry(rt *recorderTrack) []byte {
+	sps, pps := findSPSPPS(rt.samples)
+	profile := []byte{0, 0, 0}
+	if len(sps) >= 4 {
+		profile = sps[1:4]
+	}
+
+	avcC := mp4Box("avcC", concatBoxes(
+		[]byte{1}, // configurationVersion
+		profile,
+		[]byte{0xff},                      // lengthSizeMinusOne=3 | reserved
+		[]byte{0xe1}, u16(uint16(len(sps))), sps, // numSPS=1 | reserved
+		[]byte{1}, u16(uint16(len(pps))), pps, // numPPS=1
+	))
+
+	return mp4Box("avc1", visualSampleEntryBody(avcC))
+}
+
+func genericVideoEntry(mimeType string) []byte {
+	fourcc := "mp4v"
+	switch mimeType {
+	case "video/VP8":
+		fourcc = "vp08"
+	case "video/VP9":
+		fourcc = "vp09"
+	case "video/AV1":
+		fourcc = "av01"
+	}
+	return mp4Box(fourcc, visualSampleEntryBody(nil))
+}
+
+func opusEntry() []byte {
+	dOps := mp4Box("dOps", concatBoxes(
+		[]byte{0},   // version
+		[]byte{2},   // output channel count
+		u16(312),    // pre-skip
+		u32(48000),  // input sample rate
+		u16(0),      // output gain
+		[]byte{0},   // channel mapping family
+	))
+
+	body := concatBoxes(
+		make([]byte, 6), u16(1), // reserved, data_reference_index
+		make([]byte, 8), // reserved
+		u16(2), u16(16), // channelcount, samplesize
+		make([]byte, 4), // pre_defined/reserved
+		u16(48000), u16(0), // samplerate (16.16, high word only matters)
+		dOps,
+	)
+	return mp4Box("Opus", body)
+}
+
+// findSPSPPS scans depacketized H264 access units (Annex B) for the first
+// SPS (NAL type 7) and PPS (NAL type 8) it finds.
+func findSPSPPS(samples [][]byte) (sps, pps []byte) {
+	for _, sample := range samples {
+		for _, nal := range splitAnnexB(sample) {
+			if len(nal) == 0 {
+				continue
+			}
+			switch nal[0] & 0x1f {
+			case 7:
+				if sps == nil {
+					sps = nal
+				}
+			case 8:
+				if pps == nil {
+					pps = nal
+				}
+			}
+		}
+		if sps != nil && pps != nil {
+			break
+		}
+	}
+	return sps, pps
+}
+
+func splitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 {
+			continue
+		}
+		switch {
+		case data[i+2] == 1:
+			if start >= 0 {
+				nals = append(nals, data[start:i])
+			}
+			start = i + 3
+		case i+3 < len(data) && data[i+2] == 0 && data[i+3] == 1:
+			if start >= 0 {
+				nals = append(nals, data[start:i])
+			}
+			start = i + 4
+		}
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, data[start:])
+	}
+	return nals
+}
+
+// moofMdat builds the fragment header (moof) and its sample data (mdat) for
+// every track. trun's data_offset is relative to the start of moof (the
+// tfhd default-base-is-moof flag), so it can only be computed once moof's
+// total length is known - hence the two-pass patch below.
+func moofMdat(tracks []*recorderTrack, seq uint32, duration time.Duration) (moof, mdat []byte) {
+	type trackSamples struct {
+		trackID    uint32
+		samples    [][]byte
+		timescale  uint32
+		mdatOffset uint32
+	}
+
+	var tds []trackSamples
+	for i, rt := range tracks {
+		tds = append(tds, trackSamples{trackID: uint32(i + 1), samples: rt.samples, timescale: trackTimescale(rt), mdatOffset: uint32(len(mdat))})
+		for _, s := range rt.samples {
+			mdat = append(mdat, s...)
+		}
+	}
+
+	mfhd := mp4FullBox("mfhd", 0, 0, u32(seq))
+	cursor := 8 /* moof box header */ + len(mfhd)
+
+	var trafs [][]byte
+	var patchPositions []int
+	for _, td := range tds {
+		traf, dataOffsetPos := trafBox(td.trackID, td.samples, sampleDuration(td.samples, duration, td.timescale))
+		patchPositions = append(patchPositions, cursor+dataOffsetPos)
+		trafs = append(trafs, traf)
+		cursor += len(traf)
+	}
+
+	boxes := append([][]byte{mfhd}, trafs...)
+	moof = mp4Box("moof", concatBoxes(boxes...))
+
+	for i, pos := range patchPositions {
+		dataOffset := uint32(len(moof) + 8 /* mdat box header */ + int(tds[i].mdatOffset))
+		binary.BigEndian.PutUint32(moof[pos:pos+4], dataOffset)
+	}
+
+	return moof, mp4Box("mdat", mdat)
+}
+
+// trafBox builds one track's traf (tfhd + tfdt + trun) and reports the byte
+// offset of the trun's data_offset field within the returned slice, so
+// moofMdat can patch it once the full moof's length is known.
+func trafBox(trackID uint32, samples [][]byte, sampleDurationUnits uint32) (traf []byte, dataOffsetPos int) {
+	const defaultBaseIsMoof = 0x020000
+	const defaultSampleDurationPresent = 0x000008
+	tfhd := mp4FullBox("tfhd", 0, defaultBaseIsMoof|defaultSampleDurationPresent, concatBoxes(u32(trackID), u32(sampleDurationUnits)))
+	tfdt := mp4FullBox("tfdt", 1, 0, make([]byte, 8))
+
+	const dataOffsetPresent = 0x000001
+	const sampleSizePresent = 0x000200
+	var trunBody bytes.Buffer
+	trunBody.Write(u32(uint32(len(samples))))
+	trunBody.Write(u32(0)) // data_offset placeholder, patched by moofMdat
+	for _, s := range samples {
+		trunBody.Write(u32(uint32(len(s))))
+	}
+	trun := mp4FullBox("trun", 0, dataOffsetPresent|sampleSizePresent, trunBody.Bytes())
+
+	traf = mp4Box("traf", concatBoxes(tfhd, tfdt, trun))
+	// traf header(8) + tfhd + tfdt + trun header(8) + trun fullbox header(4) + sample_count(4)
+	dataOffsetPos = 8 + len(tfhd) + len(tfdt) + 8 + 4 + 4
+	return traf, dataOffsetPos
+}
+
+// sampleDuration returns the tfhd default sample duration, in units of the
+// track's own timescale (90kHz for video, 48kHz for Opus audio per
+// trackTimescale) - using the wrong one desyncs that track's playback.
+func sampleDuration(samples [][]byte, segmentDuration time.Duration, timescale uint32) uint32 {
+	if len(samples) == 0 {
+		return 0
+	}
+	return uint32(float64(segmentDuration.Milliseconds()) * float64(timescale) / 1000 / float64(len(samples)))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMp4Box(t *testing.T) {
+	box := mp4Box("ftyp", []byte("isom"))
+
+	wantLen := uint32(8 + 4)
+	if gotLen := binary.BigEndian.Uint32(box[0:4]); gotLen != wantLen {
+		t.Errorf("box length = %d, want %d", gotLen, wantLen)
+	}
+	if !bytes.Equal(box[4:8], []byte("ftyp")) {
+		t.Errorf("box type = %q, want %q", box[4:8], "ftyp")
+	}
+	if !bytes.Equal(box[8:], []byte("isom")) {
+		t.Errorf("box payload = %q, want %q", box[8:], "isom")
+	}
+}
+
+func TestMp4FullBox(t *testing.T) {
+	box := mp4FullBox("mfhd", 1, 0x020304, []byte{0xaa})
+
+	if !bytes.Equal(box[4:8], []byte("mfhd")) {
+		t.Fatalf("box type = %q, want %q", box[4:8], "mfhd")
+	}
+	if version := box[8]; version != 1 {
+		t.Errorf("version = %d, want 1", version)
+	}
+	if flags := [3]byte{box[9], box[10], box[11]}; flags != [3]byte{0x02, 0x03, 0x04} {
+		t.Errorf("flags = %v, want [2 3 4]", flags)
+	}
+	if box[12] != 0xaa {
+		t.Errorf("payload byte = %#x, want %#x", box[12], 0xaa)
+	}
+}
+
+func TestConcatBoxes(t *testing.T) {
+	got := concatBoxes([]byte{1, 2}, []byte{3}, []byte{4, 5})
+	want := []byte{1, 2, 3, 4, 5}
+	if !bytes.Equal(got, want) {
+		t.Errorf("concatBoxes() = %v, want %v", got, want)
+	}
+}
+
+func TestSampleDuration(t *testing.T) {
+	tests := []struct {
+		name            string
+		samples         [][]byte
+		segmentDuration time.Duration
+		timescale       uint32
+		want            uint32
+	}{
+		{"no samples", nil, time.Second, 90000, 0},
+		{"video at 90kHz, 30 samples over 1s", make([][]byte, 30), time.Second, 90000, 3000},
+		{"audio at 48kHz, 50 samples over 1s", make([][]byte, 50), time.Second, 48000, 960},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampleDuration(tt.samples, tt.segmentDuration, tt.timescale); got != tt.want {
+				t.Errorf("sampleDuration() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
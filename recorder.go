@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// RecorderConfig tunes the recording/HLS egress subsystem; see
+// loadRecorderConfig for the env vars that populate it.
+type RecorderConfig struct {
+	Enabled   bool
+	Dir       string
+	SegmentMS int
+	Window    int
+}
+
+// recorderConfig is loaded once in main() and read by every Stream when its
+// broadcaster connects, same pattern as the package-level registry.
+var recorderConfig RecorderConfig
+
+func loadRecorderConfig() RecorderConfig {
+	return RecorderConfig{
+		Enabled:   os.Getenv("RECORD_ENABLED") == "true",
+		Dir:       getEnvOrDefault("RECORD_DIR", "recordings"),
+		SegmentMS: getEnvIntOrDefault("HLS_SEGMENT_MS", 4000),
+		Window:    getEnvIntOrDefault("HLS_WINDOW", 6),
+	}
+}
+
+func getEnvIntOrDefault(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// depacketizer is the subset of rtp.Depacketizer this file actually uses.
+// codecs.AV1Packet doesn't implement the full rtp.Depacketizer interface (no
+// IsPartitionHead), but writeRTP never needs that method - it tracks access
+// unit boundaries off the RTP marker bit instead - so narrowing the field to
+// just Unmarshal lets AV1 depacketize here like every other codec.
+type depacketizer interface {
+	Unmarshal(packet []byte) ([]byte, error)
+}
+
+// recorderTrack accumulates one RTP track's depacketized access units for
+// the segment currently being built.
+type recorderTrack struct {
+	kind     webrtc.RTPCodecType
+	mimeType string
+	depack   depacketizer // nil for Opus, whose RTP payload needs no depacketization
+	pending  []byte       // partial access unit for the in-flight RTP marker run
+	samples  [][]byte     // completed access units waiting to be muxed
+}
+
+func newRecorderTrack(kind webrtc.RTPCodecType, mimeType string) *recorderTrack {
+	rt := &recorderTrack{kind: kind, mimeType: mimeType}
+	switch mimeType {
+	case "video/H264":
+		rt.depack = &codecs.H264Packet{}
+	case "video/VP8":
+		rt.depack = &codecs.VP8Packet{}
+	case "video/VP9":
+		rt.depack = &codecs.VP9Packet{}
+	case "video/AV1":
+		rt.depack = &codecs.AV1Packet{}
+	}
+	return rt
+}
+
+func (rt *recorderTrack) writeRTP(packet *rtp.Packet) {
+	if rt.depack == nil {
+		// Opus: the RTP payload is already a complete frame, one per packet.
+		rt.samples = append(rt.samples, append([]byte(nil), packet.Payload...))
+		return
+	}
+
+	payload, err := rt.depack.Unmarshal(packet.Payload)
+	if err != nil {
+		log.Printf("Recorder failed to depacketize %s packet: %v", rt.mimeType, err)
+		return
+	}
+	rt.pending = append(rt.pending, payload...)
+
+	if packet.Marker {
+		rt.samples = append(rt.samples, rt.pending)
+		rt.pending = nil
+	}
+}
+
+// hlsSegment is one entry in the rolling HLS playlist window.
+type hlsSegment struct {
+	name     string
+	duration float64
+}
+
+// Recorder taps a stream's RTP tracks, depacketizes them into access units,
+// and periodically muxes the accumulated samples into a self-contained MP4
+// segment. Each segment doubles as a DVR recording artifact
+// (recordings/<streamKey>/<timestamp>.mp4) and as an HLS media segment
+// served from /hls/<streamKey>/, so viewers who can't use WebRTC (Safari's
+// iOS restrictions, corporate networks blocking UDP) can still watch, and
+// operators get replay for free.
+//
+// Muxing currently covers the common OBS/browser pairing of one H264 (or
+// VP8/VP9/AV1) video track plus one Opus audio track with a best-effort
+// sample entry; per-viewer simulcast renditions aren't recorded, since an
+// HLS viewer only ever watches one rendition at a time and picking which
+// one to record is a follow-up decision, not a blocker for the common case.
+type Recorder struct {
+	streamKey string
+	dir       string
+	segmentMS int
+	window    int
+
+	mutex    sync.Mutex
+	tracks   map[string]*recorderTrack // keyed by local track ID
+	segStart time.Time
+	seq      uint32
+	segments []hlsSegment
+	dropped  int
+	closed   bool
+}
+
+func newRecorder(streamKey string, cfg RecorderConfig) (*Recorder, error) {
+	dir := filepath.Join(cfg.Dir, streamKey)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create recording dir: %w", err)
+	}
+
+	log.Printf("Recording stream %s to %s", streamKey, dir)
+	return &Recorder{
+		streamKey: streamKey,
+		dir:       dir,
+		segmentMS: cfg.SegmentMS,
+		window:    cfg.Window,
+		tracks:    make(map[string]*recorderTrack),
+		segStart:  time.Now(),
+	}, nil
+}
+
+// WritePacket feeds one RTP packet from the broadcaster into the recorder.
+// trackID should be stable for the track's lifetime; the local track ID
+// handleBroadcaster/handleWHIP already assign works well.
+func (r *Recorder) WritePacket(trackID string, kind webrtc.RTPCodecType, mimeType string, packet *rtp.Packet) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+
+	rt, ok := r.tracks[trackID]
+	if !ok {
+		rt = newRecorderTrack(kind, mimeType)
+		r.tracks[trackID] = rt
+	}
+	rt.writeRTP(packet)
+
+	if time.Since(r.segStart) >= time.Duration(r.segmentMS)*time.Millisecond {
+		r.rollSegment()
+	}
+}
+
+// rollSegment must be called with r.mutex held. It muxes every track's
+// accumulated samples into the next segment file, updates the playlist
+// window, and starts a fresh segment.
+func (r *Recorder) rollSegment() {
+	duration := time.Since(r.segStart)
+	defer func() { r.segStart = time.Now() }()
+
+	tracks := make([]*recorderTrack, 0, len(r.tracks))
+	for _, rt := range r.tracks {
+		if len(rt.samples) > 0 {
+			tracks = append(tracks, rt)
+		}
+	}
+	if len(tracks) == 0 {
+		return
+	}
+
+	name := fmt.Sprintf("%d.mp4", time.Now().UnixMilli())
+	path := filepath.Join(r.dir, name)
+
+	if err := muxSegment(path, tracks, r.seq, duration); err != nil {
+		log.Printf("Recorder failed to mux segment %s for stream %s: %v", name, r.streamKey, err)
+	} else {
+		r.seq++
+		r.segments = append(r.segments, hlsSegment{name: name, duration: duration.Seconds()})
+		if len(r.segments) > r.window {
+			dropped := r.segments[0]
+			r.segments = r.segments[1:]
+			r.dropped++
+			os.Remove(filepath.Join(r.dir, dropped.name))
+		}
+		if err := writeHLSPlaylist(r.dir, r.segments, r.dropped); err != nil {
+			log.Printf("Recorder failed to write HLS playlist for stream %s: %v", r.streamKey, err)
+		}
+	}
+
+	for _, rt := range tracks {
+		rt.samples = nil
+	}
+}
+
+// Close flushes whatever's been accumulated since the last segment and
+// stops the recorder; called when the broadcaster disconnects.
+func (r *Recorder) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	r.rollSegment()
+	r.closed = true
+	log.Printf("Stopped recording stream %s after %d segments", r.streamKey, r.seq)
+}
+
+// writeHLSPlaylist rewrites the stream's index.m3u8 to reflect the current
+// rolling window of segments. Each segment is self-initializing (it carries
+// its own ftyp/moov ahead of its moof/mdat), so the playlist doesn't need
+// an EXT-X-MAP pointing at a shared init segment.
+func writeHLSPlaylist(dir string, segments []hlsSegment, mediaSequence int) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+
+	target := 1
+	for _, s := range segments {
+		if d := int(s.duration + 0.999); d > target {
+			target = d
+		}
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSequence)
+
+	for _, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration, s.name)
+	}
+
+	return os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(b.String()), 0o644)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// layerRank orders simulcast RIDs from lowest to highest bitrate, matching
+// the "l"/"m"/"h" convention most encoders (and our client) use for
+// a=simulcast.
+var layerRank = map[string]int{"l": 0, "m": 1, "h": 2}
+
+// SimulcastLayer is one RTP layer (RID) of a broadcaster's simulcast video
+// track: its SSRC/codec plus every viewer forwarder currently subscribed to
+// it.
+type SimulcastLayer struct {
+	RID   string
+	SSRC  uint32
+	Codec webrtc.RTPCodecCapability
+
+	mutex       sync.RWMutex
+	subscribers map[*LayerForwarder]struct{}
+}
+
+func newSimulcastLayer(rid string, ssrc uint32, codec webrtc.RTPCodecCapability) *SimulcastLayer {
+	return &SimulcastLayer{
+		RID:         rid,
+		SSRC:        ssrc,
+		Codec:       codec,
+		subscribers: make(map[*LayerForwarder]struct{}),
+	}
+}
+
+func (l *SimulcastLayer) subscribe(f *LayerForwarder) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.subscribers[f] = struct{}{}
+}
+
+func (l *SimulcastLayer) unsubscribe(f *LayerForwarder) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.subscribers, f)
+}
+
+// publish hands a packet read from this layer's remote track to every
+// subscribed viewer forwarder.
+func (l *SimulcastLayer) publish(packet *rtp.Packet) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for f := range l.subscribers {
+		f.forward(l, packet)
+	}
+}
+
+// LayerForwarder is one viewer's subscription to a broadcaster's simulcast
+// track. It forwards whichever layer it's currently subscribed to into the
+// viewer's own local track, rewriting sequence numbers and timestamps
+// across a layer switch so the jump between two independent encoders'
+// numbering doesn't look like a dropped or reordered packet to the
+// decoder.
+type LayerForwarder struct {
+	localTrack *webrtc.TrackLocalStaticRTP
+
+	mutex     sync.Mutex
+	layer     *SimulcastLayer
+	primed    bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+}
+
+func newLayerForwarder(localTrack *webrtc.TrackLocalStaticRTP) *LayerForwarder {
+	return &LayerForwarder{localTrack: localTrack}
+}
+
+// switchTo moves this forwarder onto a new layer. The offset that bridges
+// the old and new numbering is computed lazily from the first packet that
+// arrives on the new layer (see forward), since we don't know the new
+// layer's current sequence/timestamp until then.
+func (f *LayerForwarder) switchTo(layer *SimulcastLayer) {
+	f.mutex.Lock()
+	prev := f.layer
+	if prev == layer {
+		f.mutex.Unlock()
+		return
+	}
+	f.layer = layer
+	f.primed = false
+	f.mutex.Unlock()
+
+	if prev != nil {
+		prev.unsubscribe(f)
+	}
+	layer.subscribe(f)
+}
+
+// close detaches this forwarder from whatever layer it's on, e.g. when the
+// viewer disconnects.
+func (f *LayerForwarder) close() {
+	f.mutex.Lock()
+	layer := f.layer
+	f.layer = nil
+	f.mutex.Unlock()
+
+	if layer != nil {
+		layer.unsubscribe(f)
+	}
+}
+
+// currentRID reports which layer this forwarder is subscribed to, or "" if
+// none yet.
+func (f *LayerForwarder) currentRID() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.layer == nil {
+		return ""
+	}
+	return f.layer.RID
+}
+
+func (f *LayerForwarder) forward(layer *SimulcastLayer, packet *rtp.Packet) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.layer != layer {
+		// A switch raced this packet on the old layer; drop it.
+		return
+	}
+
+	if !f.primed {
+		// First packet since (re)subscribing: pick offsets so our outgoing
+		// sequence/timestamp continue on from wherever we left off rather
+		// than jumping to this layer's independent numbering.
+		f.seqOffset = f.lastSeq + 1 - packet.SequenceNumber
+		f.tsOffset = f.lastTS + 3000 - packet.Timestamp // ~1 frame at 90kHz/30fps
+		f.primed = true
+	}
+
+	out := *packet
+	out.SequenceNumber = packet.SequenceNumber + f.seqOffset
+	out.Timestamp = packet.Timestamp + f.tsOffset
+	f.lastSeq = out.SequenceNumber
+	f.lastTS = out.Timestamp
+
+	if err := f.localTrack.WriteRTP(&out); err != nil {
+		log.Printf("Failed to write RTP for simulcast layer forwarder: %v", err)
+	}
+}
+
+// Bandwidth thresholds roughly matching a typical 3-layer simulcast
+// encoding (low ~150kbps, mid ~500kbps, high ~1.5mbps base layer).
+const (
+	bitrateThresholdMid  = 500_000
+	bitrateThresholdHigh = 1_500_000
+)
+
+func ridForBitrate(bps float32) string {
+	switch {
+	case bps >= bitrateThresholdHigh:
+		return "h"
+	case bps >= bitrateThresholdMid:
+		return "m"
+	default:
+		return "l"
+	}
+}
+
+// monitorViewerBandwidth reads RTCP feedback (REMB) off a viewer's RTP
+// sender and switches their forwarder to a better- or worse-matched
+// simulcast layer whenever the estimate crosses a threshold. This is the
+// lightweight bandwidth estimator the adaptive SFU relies on in place of a
+// full GCC implementation.
+func monitorViewerBandwidth(rtpSender *webrtc.RTPSender, stream *Stream, forwarder *LayerForwarder, viewerID string) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := rtpSender.Read(buf)
+		if err != nil {
+			return
+		}
+
+		packets, err := rtcp.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, pkt := range packets {
+			remb, ok := pkt.(*rtcp.ReceiverEstimatedMaximumBitrate)
+			if !ok {
+				continue
+			}
+
+			rid := ridForBitrate(remb.Bitrate)
+			if rid == forwarder.currentRID() {
+				continue
+			}
+
+			layer, ok := stream.VideoLayer(rid)
+			if !ok {
+				continue
+			}
+
+			log.Printf("Viewer %s bandwidth estimate %.0fbps, switching to simulcast layer %q", viewerID, remb.Bitrate, rid)
+			forwarder.switchTo(layer)
+		}
+	}
+}
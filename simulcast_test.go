@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRidForBitrate(t *testing.T) {
+	tests := []struct {
+		bps  float32
+		want string
+	}{
+		{0, "l"},
+		{bitrateThresholdMid - 1, "l"},
+		{bitrateThresholdMid, "m"},
+		{bitrateThresholdHigh - 1, "m"},
+		{bitrateThresholdHigh, "h"},
+		{bitrateThresholdHigh * 10, "h"},
+	}
+
+	for _, tt := range tests {
+		if got := ridForBitrate(tt.bps); got != tt.want {
+			t.Errorf("ridForBitrate(%v) = %q, want %q", tt.bps, got, tt.want)
+		}
+	}
+}
+
+func newTestForwarder(t *testing.T) *LayerForwarder {
+	t.Helper()
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "video/VP8"}, "id", "stream")
+	if err != nil {
+		t.Fatalf("NewTrackLocalStaticRTP: %v", err)
+	}
+	return newLayerForwarder(track)
+}
+
+// TestLayerForwarderSwitchRewritesNumbering checks that forward() makes a
+// layer switch look continuous to the viewer: each independent encoder's
+// sequence numbers/timestamps start from whatever it happens to be counting,
+// but the forwarder's output must keep climbing by one sequence number (and
+// by the source's own timestamp delta) per packet, even across a switch.
+func TestLayerForwarderSwitchRewritesNumbering(t *testing.T) {
+	f := newTestForwarder(t)
+
+	low := newSimulcastLayer("l", 1, webrtc.RTPCodecCapability{MimeType: "video/VP8"})
+	high := newSimulcastLayer("h", 2, webrtc.RTPCodecCapability{MimeType: "video/VP8"})
+
+	f.switchTo(low)
+	f.forward(low, &rtp.Packet{Header: rtp.Header{SequenceNumber: 1000, Timestamp: 90000}})
+	seq1, ts1 := f.lastSeq, f.lastTS
+
+	f.forward(low, &rtp.Packet{Header: rtp.Header{SequenceNumber: 1001, Timestamp: 93000}})
+	if f.lastSeq != seq1+1 {
+		t.Errorf("lastSeq after second packet on the same layer = %d, want %d", f.lastSeq, seq1+1)
+	}
+	if f.lastTS != ts1+3000 {
+		t.Errorf("lastTS after second packet on the same layer = %d, want %d", f.lastTS, ts1+3000)
+	}
+	seq2, ts2 := f.lastSeq, f.lastTS
+
+	// The new layer's encoder has its own, unrelated numbering; the switch
+	// must still produce output that continues on from seq2/ts2.
+	f.switchTo(high)
+	f.forward(high, &rtp.Packet{Header: rtp.Header{SequenceNumber: 50, Timestamp: 5000}})
+	if f.lastSeq != seq2+1 {
+		t.Errorf("lastSeq after switch = %d, want %d (continuing on from the old layer)", f.lastSeq, seq2+1)
+	}
+	if f.lastTS != ts2+3000 {
+		t.Errorf("lastTS after switch = %d, want %d (previous + ~1 frame)", f.lastTS, ts2+3000)
+	}
+	seq3, ts3 := f.lastSeq, f.lastTS
+
+	// A second packet on the new layer should continue from there using the
+	// new layer's own numbering deltas.
+	f.forward(high, &rtp.Packet{Header: rtp.Header{SequenceNumber: 51, Timestamp: 8000}})
+	if f.lastSeq != seq3+1 {
+		t.Errorf("lastSeq after second packet on new layer = %d, want %d", f.lastSeq, seq3+1)
+	}
+	if f.lastTS != ts3+3000 {
+		t.Errorf("lastTS after second packet on new layer = %d, want %d", f.lastTS, ts3+3000)
+	}
+}
+
+// TestLayerForwarderDropsPacketFromStaleLayer checks that forward() ignores
+// a packet delivered from a layer the forwarder has already switched away
+// from, e.g. one already in flight when switchTo races it.
+func TestLayerForwarderDropsPacketFromStaleLayer(t *testing.T) {
+	f := newTestForwarder(t)
+
+	low := newSimulcastLayer("l", 1, webrtc.RTPCodecCapability{MimeType: "video/VP8"})
+	high := newSimulcastLayer("h", 2, webrtc.RTPCodecCapability{MimeType: "video/VP8"})
+
+	f.switchTo(low)
+	f.forward(low, &rtp.Packet{Header: rtp.Header{SequenceNumber: 1, Timestamp: 90000}})
+	seq, ts := f.lastSeq, f.lastTS
+	f.switchTo(high)
+
+	f.forward(low, &rtp.Packet{Header: rtp.Header{SequenceNumber: 2, Timestamp: 93000}})
+
+	if f.lastSeq != seq || f.lastTS != ts {
+		t.Errorf("forwarding a stale-layer packet changed state to %d/%d, want unchanged %d/%d", f.lastSeq, f.lastTS, seq, ts)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Stream owns everything for a single stream key: its broadcaster
+// connection, the set of viewers subscribed to it, and the RTP fan-out
+// between the two. Replacing the old global broadcaster/viewers singletons
+// with one Stream per key is what lets the server host multiple concurrent
+// broadcasts.
+type Stream struct {
+	Key         string
+	Broadcaster *WebRTCConnection
+	Viewers     map[string]*WebRTCConnection
+	// VideoLayers holds the broadcaster's simulcast layers, keyed by RID
+	// ("l"/"m"/"h"). Empty when the broadcaster isn't publishing simulcast.
+	VideoLayers map[string]*SimulcastLayer
+	// Recorder is non-nil while RECORD_ENABLED is set and this stream has a
+	// broadcaster; see recorder.go.
+	Recorder *Recorder
+	mutex    sync.RWMutex
+}
+
+func newStream(key string) *Stream {
+	return &Stream{
+		Key:         key,
+		Viewers:     make(map[string]*WebRTCConnection),
+		VideoLayers: make(map[string]*SimulcastLayer),
+	}
+}
+
+// SetVideoLayer registers (or replaces) one of the broadcaster's simulcast
+// layers.
+func (s *Stream) SetVideoLayer(rid string, layer *SimulcastLayer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.VideoLayers[rid] = layer
+}
+
+// VideoLayer looks up a simulcast layer by RID.
+func (s *Stream) VideoLayer(rid string) (*SimulcastLayer, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	l, ok := s.VideoLayers[rid]
+	return l, ok
+}
+
+// BestVideoLayer returns the highest-bitrate simulcast layer currently
+// published, or nil if the broadcaster isn't publishing simulcast.
+func (s *Stream) BestVideoLayer() *SimulcastLayer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var best *SimulcastLayer
+	bestRank := -1
+	for rid, layer := range s.VideoLayers {
+		if rank := layerRank[rid]; rank > bestRank {
+			bestRank = rank
+			best = layer
+		}
+	}
+	return best
+}
+
+// SetBroadcaster replaces whoever is currently publishing to this stream,
+// starting a recorder alongside it if RECORD_ENABLED is set.
+func (s *Stream) SetBroadcaster(b *WebRTCConnection) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Broadcaster = b
+
+	if recorderConfig.Enabled {
+		rec, err := newRecorder(s.Key, recorderConfig)
+		if err != nil {
+			log.Printf("Failed to start recorder for stream %s: %v", s.Key, err)
+		} else {
+			s.Recorder = rec
+		}
+	}
+}
+
+// ClearBroadcaster removes b as the broadcaster, but only if it's still the
+// current one — guards against a stale disconnect racing a reconnect. Also
+// stops the recorder, flushing whatever's left as a final segment.
+func (s *Stream) ClearBroadcaster(b *WebRTCConnection) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Broadcaster == b {
+		s.Broadcaster = nil
+		if s.Recorder != nil {
+			s.Recorder.Close()
+			s.Recorder = nil
+		}
+	}
+}
+
+// ActiveRecorder returns the stream's recorder, or nil if recording isn't
+// enabled or no broadcaster is connected.
+func (s *Stream) ActiveRecorder() *Recorder {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.Recorder
+}
+
+// BroadcasterTracks returns the current broadcaster's tracks, or nil if the
+// stream isn't live.
+func (s *Stream) BroadcasterTracks() []*webrtc.TrackLocalStaticRTP {
+	s.mutex.RLock()
+	broadcaster := s.Broadcaster
+	s.mutex.RUnlock()
+	if broadcaster == nil {
+		return nil
+	}
+	return broadcaster.Tracks()
+}
+
+// SendKeyFrameRequest asks the broadcaster to produce a fresh keyframe on
+// every video track by sending an RTCP PLI. Call this whenever a new viewer
+// subscribes so they aren't stuck looking at a black screen until the next
+// natural IDR.
+func (s *Stream) SendKeyFrameRequest() {
+	s.mutex.RLock()
+	broadcaster := s.Broadcaster
+	layers := make([]*SimulcastLayer, 0, len(s.VideoLayers))
+	for _, layer := range s.VideoLayers {
+		layers = append(layers, layer)
+	}
+	s.mutex.RUnlock()
+
+	if broadcaster == nil {
+		return
+	}
+
+	trackInfo := broadcaster.TrackInfos()
+	ssrcs := make([]uint32, 0, len(trackInfo)+len(layers))
+	for _, info := range trackInfo {
+		if info.Kind == webrtc.RTPCodecTypeVideo {
+			ssrcs = append(ssrcs, info.SSRC)
+		}
+	}
+	for _, layer := range layers {
+		ssrcs = append(ssrcs, layer.SSRC)
+	}
+
+	for _, ssrc := range ssrcs {
+		pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}
+		if err := broadcaster.PeerConnection.WriteRTCP(pli); err != nil {
+			log.Printf("Failed to send PLI on stream %s: %v", s.Key, err)
+		}
+	}
+}
+
+func (s *Stream) AddViewer(id string, v *WebRTCConnection) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Viewers[id] = v
+}
+
+func (s *Stream) RemoveViewer(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.Viewers, id)
+}
+
+func (s *Stream) ViewerCount() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.Viewers)
+}
+
+// StreamRegistry maps a stream key to its Stream and enforces the
+// configured allow-list of keys a broadcaster may publish under.
+type StreamRegistry struct {
+	mutex       sync.RWMutex
+	streams     map[string]*Stream
+	allowedKeys map[string]bool
+	defaultKey  string
+}
+
+func newStreamRegistry(defaultKey string, allowedKeys []string) *StreamRegistry {
+	allowed := make(map[string]bool, len(allowedKeys))
+	for _, k := range allowedKeys {
+		allowed[k] = true
+	}
+	return &StreamRegistry{
+		streams:     make(map[string]*Stream),
+		allowedKeys: allowed,
+		defaultKey:  defaultKey,
+	}
+}
+
+// DefaultKey returns the configured ServerConfig.StreamKey, for callers (the
+// WebSocket/WHIP/WHEP viewer paths) that need to pick a stream when the
+// client didn't specify one. Re-reading os.Getenv("STREAM_KEY") directly
+// would silently diverge from this if STREAM_KEY is unset and
+// getEnvOrDefault's fallback kicks in.
+func (r *StreamRegistry) DefaultKey() string {
+	return r.defaultKey
+}
+
+// IsAllowedKey reports whether key is in the server's configured allow-list.
+func (r *StreamRegistry) IsAllowedKey(key string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.allowedKeys[key]
+}
+
+// GetOrCreate returns the Stream for key, creating an empty one if this is
+// the first time anyone (broadcaster or viewer) has referenced it.
+func (r *StreamRegistry) GetOrCreate(key string) *Stream {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, ok := r.streams[key]
+	if !ok {
+		s = newStream(key)
+		r.streams[key] = s
+	}
+	return s
+}
+
+// Get looks up a stream without creating it, for callers (like unsubscribe)
+// that shouldn't register a stream key just by asking about it.
+func (r *StreamRegistry) Get(key string) (*Stream, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, ok := r.streams[key]
+	return s, ok
+}
+
+// streamInfo is the JSON shape returned by GET /streams.
+type streamInfo struct {
+	Key         string `json:"key"`
+	Live        bool   `json:"live"`
+	ViewerCount int    `json:"viewerCount"`
+}
+
+// List returns a snapshot of every known stream and its viewer count.
+func (r *StreamRegistry) List() []streamInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	infos := make([]streamInfo, 0, len(r.streams))
+	for _, s := range r.streams {
+		s.mutex.RLock()
+		infos = append(infos, streamInfo{
+			Key:         s.Key,
+			Live:        s.Broadcaster != nil,
+			ViewerCount: len(s.Viewers),
+		})
+		s.mutex.RUnlock()
+	}
+	return infos
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// webrtcAPI is a single process-wide *webrtc.API. Building one registers
+// codecs and interceptors, so every PeerConnection (broadcaster and viewer
+// alike) must share it to negotiate a consistent codec set.
+var webrtcAPI *webrtc.API
+
+func init() {
+	api, err := newWebRTCAPI()
+	if err != nil {
+		log.Fatalf("Failed to build WebRTC API: %v", err)
+	}
+	webrtcAPI = api
+}
+
+// newWebRTCAPI registers the codecs the SFU relays - Opus for audio, and
+// H264/VP8/VP9/AV1 for video, covering the codecs OBS and browser clients
+// commonly negotiate - plus the default interceptor chain (NACK-based
+// retransmission, RTCP reports, TWCC bandwidth feedback) so viewers get
+// smooth playback without us hand-rolling a jitter buffer.
+func newWebRTCAPI() (*webrtc.API, error) {
+	m := &webrtc.MediaEngine{}
+
+	audioCodecs := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/opus", ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+			PayloadType:        111,
+		},
+	}
+	for _, codec := range audioCodecs {
+		if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+
+	videoCodecs := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/VP8", ClockRate: 90000},
+			PayloadType:        96,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/VP9", ClockRate: 90000, SDPFmtpLine: "profile-id=0"},
+			PayloadType:        98,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/H264", ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"},
+			PayloadType:        102,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/AV1", ClockRate: 90000},
+			PayloadType:        105,
+		},
+	}
+	for _, codec := range videoCodecs {
+		if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+
+	// Simulcast demuxing by RID requires these header extensions: mid pairs
+	// an RTP stream with its m= line, and rtp-stream-id/repaired-rtp-stream-id
+	// carry the RID itself (and its retransmission counterpart) until the
+	// first RTCP SR lets pion key off SSRC instead. Without these,
+	// attachBroadcasterTrack's RID branch never fires.
+	for _, extension := range []string{sdp.SDESMidURI, sdp.SDESRTPStreamIDURI, "urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id"} {
+		if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+
+	s := webrtc.SettingEngine{}
+	configureSettingEngine(&s)
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(s)), nil
+}
+
+// configureSettingEngine applies the NAT-traversal knobs a real deployment
+// behind a firewall or cloud NAT needs: a fixed UDP port range to open in
+// the firewall, a 1:1 NAT mapping for the public IP cloud providers hide
+// behind, and/or a specific set of interfaces to bind to.
+func configureSettingEngine(s *webrtc.SettingEngine) {
+	if raw := os.Getenv("WEBRTC_UDP_PORT_RANGE"); raw != "" {
+		minPort, maxPort, ok := parsePortRange(raw)
+		if !ok {
+			log.Printf("Invalid WEBRTC_UDP_PORT_RANGE %q, ignoring", raw)
+		} else if err := s.SetEphemeralUDPPortRange(minPort, maxPort); err != nil {
+			log.Printf("Failed to set WEBRTC_UDP_PORT_RANGE %q: %v", raw, err)
+		}
+	}
+
+	if raw := os.Getenv("WEBRTC_NAT_1TO1_IPS"); raw != "" {
+		var ips []string
+		for _, ip := range strings.Split(raw, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) > 0 {
+			s.SetNAT1To1IPs(ips, webrtc.ICECandidateTypeHost)
+		}
+	}
+
+	if raw := os.Getenv("WEBRTC_INTERFACES"); raw != "" {
+		allowed := make(map[string]struct{})
+		for _, iface := range strings.Split(raw, ",") {
+			if iface = strings.TrimSpace(iface); iface != "" {
+				allowed[iface] = struct{}{}
+			}
+		}
+		s.SetInterfaceFilter(func(iface string) bool {
+			_, ok := allowed[iface]
+			return ok
+		})
+	}
+}
+
+func parsePortRange(raw string) (min, max uint16, ok bool) {
+	parts := strings.SplitN(raw, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	minVal, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	maxVal, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint16(minVal), uint16(maxVal), true
+}
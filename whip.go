@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// WHIP (WebRTC-HTTP Ingestion Protocol, RFC draft) and WHEP (WebRTC-HTTP
+// Egress Protocol) endpoints. These sit alongside the WebSocket signaling in
+// handleBroadcaster/handleViewer and let standard tooling (OBS's WHIP
+// output, ffmpeg/gstreamer WHEP players) publish and subscribe without our
+// custom JS signaling client.
+
+// whipSession tracks the peer connection (and the stream it belongs to)
+// behind a WHIP/WHEP resource so a later PATCH (trickle ICE) or DELETE
+// (teardown) can find it again and detach cleanly from the stream.
+type whipSession struct {
+	PeerConnection *webrtc.PeerConnection
+	Stream         *Stream
+	Connection     *WebRTCConnection
+	ViewerID       string          // set for WHEP sessions, empty for WHIP
+	Forwarder      *LayerForwarder // set if the WHEP viewer subscribed to simulcast video
+}
+
+var (
+	whipSessions      = make(map[string]*whipSession)
+	whepSessions      = make(map[string]*whipSession)
+	whipSessionsMutex sync.Mutex
+)
+
+func generateResourceID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// checkStreamKeyBearer validates the Authorization header against the
+// stream's key, per the WHIP spec's bearer-token auth scheme. The stream key
+// doubles as the bearer token, so possessing it is what authorizes a
+// publish.
+func checkStreamKeyBearer(r *http.Request, streamKey string) bool {
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return false
+	}
+	return token == streamKey
+}
+
+// handleWHIP accepts an SDP offer from a WHIP-compatible broadcaster (e.g.
+// OBS) and publishes it as the broadcaster of the stream named by ?stream=
+// (or the default stream key), mirroring the WebSocket "offer" flow in
+// handleBroadcaster.
+func handleWHIP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	streamKey := r.URL.Query().Get("stream")
+	if streamKey == "" {
+		streamKey = registry.DefaultKey()
+	}
+
+	if !registry.IsAllowedKey(streamKey) || !checkStreamKeyBearer(r, streamKey) {
+		http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "application/sdp" {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read offer", http.StatusBadRequest)
+		return
+	}
+
+	pc, err := createPeerConnection()
+	if err != nil {
+		log.Printf("Create PC error: %v", err)
+		http.Error(w, "failed to create peer connection", http.StatusInternalServerError)
+		return
+	}
+
+	b := &WebRTCConnection{PeerConnection: pc}
+	stream := registry.GetOrCreate(streamKey)
+	stream.SetBroadcaster(b)
+
+	pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		attachBroadcasterTrack(stream, b, remoteTrack)
+	})
+
+	answerSDP, err := negotiateWHIPOffer(pc, string(offer))
+	if err != nil {
+		log.Printf("WHIP negotiation error: %v", err)
+		stream.ClearBroadcaster(b)
+		pc.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resourceID, err := generateResourceID()
+	if err != nil {
+		log.Printf("Failed to generate resource ID: %v", err)
+		stream.ClearBroadcaster(b)
+		pc.Close()
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	whipSessionsMutex.Lock()
+	whipSessions[resourceID] = &whipSession{PeerConnection: pc, Stream: stream, Connection: b}
+	whipSessionsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whip/"+resourceID)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answerSDP))
+}
+
+// handleWHEP accepts an SDP offer from a WHEP player and answers it with the
+// current broadcaster's tracks, mirroring the WebSocket "offer" flow in
+// handleViewer. Gated by the same bearer-token auth as handleWHIP, since an
+// open playback endpoint would let anyone spin up unbounded PeerConnections.
+func handleWHEP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	streamKey := r.URL.Query().Get("stream")
+	if streamKey == "" {
+		streamKey = registry.DefaultKey()
+	}
+
+	if !registry.IsAllowedKey(streamKey) || !checkStreamKeyBearer(r, streamKey) {
+		http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "application/sdp" {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read offer", http.StatusBadRequest)
+		return
+	}
+
+	pc, err := createPeerConnection()
+	if err != nil {
+		log.Printf("Create PC error: %v", err)
+		http.Error(w, "failed to create peer connection", http.StatusInternalServerError)
+		return
+	}
+
+	stream := registry.GetOrCreate(streamKey)
+
+	viewerID := generateViewerID()
+	v := &WebRTCConnection{PeerConnection: pc}
+	stream.AddViewer(viewerID, v)
+
+	// WHEP has no control channel back to the server, so unlike the
+	// WebSocket viewer path there's no manual "selectLayer" after this.
+	forwarder := subscribeViewerToStream(pc, stream, viewerID)
+
+	answerSDP, err := negotiateWHIPOffer(pc, string(offer))
+	if err != nil {
+		log.Printf("WHEP negotiation error: %v", err)
+		stream.RemoveViewer(viewerID)
+		if forwarder != nil {
+			forwarder.close()
+		}
+		pc.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resourceID, err := generateResourceID()
+	if err != nil {
+		log.Printf("Failed to generate resource ID: %v", err)
+		stream.RemoveViewer(viewerID)
+		if forwarder != nil {
+			forwarder.close()
+		}
+		pc.Close()
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	whipSessionsMutex.Lock()
+	whepSessions[resourceID] = &whipSession{PeerConnection: pc, Stream: stream, Connection: v, ViewerID: viewerID, Forwarder: forwarder}
+	whipSessionsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+resourceID)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answerSDP))
+}
+
+// negotiateWHIPOffer runs the common WHIP/WHEP offer/answer exchange: set
+// the remote offer, create and set the local answer, and wait for ICE
+// gathering to finish so the returned SDP carries all host/srflx candidates.
+func negotiateWHIPOffer(pc *webrtc.PeerConnection, offerSDP string) (string, error) {
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offerSDP,
+	}); err != nil {
+		return "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gatherComplete
+
+	return pc.LocalDescription().SDP, nil
+}
+
+// handleWHIPResource handles PATCH (trickle-ICE candidates) and DELETE
+// (session teardown) on a WHIP resource at /whip/<resourceID>.
+func handleWHIPResource(w http.ResponseWriter, r *http.Request) {
+	resourceID := strings.TrimPrefix(r.URL.Path, "/whip/")
+	handleSignalingResource(w, r, whipSessions, resourceID)
+}
+
+// handleWHEPResource handles PATCH and DELETE on a WHEP resource at
+// /whep/<resourceID>.
+func handleWHEPResource(w http.ResponseWriter, r *http.Request) {
+	resourceID := strings.TrimPrefix(r.URL.Path, "/whep/")
+	handleSignalingResource(w, r, whepSessions, resourceID)
+}
+
+func handleSignalingResource(w http.ResponseWriter, r *http.Request, sessions map[string]*whipSession, resourceID string) {
+	whipSessionsMutex.Lock()
+	session, ok := sessions[resourceID]
+	whipSessionsMutex.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown resource", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		if ct := r.Header.Get("Content-Type"); ct != "application/trickle-ice-sdpfrag" {
+			http.Error(w, "Content-Type must be application/trickle-ice-sdpfrag", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		frag, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read ICE fragment", http.StatusBadRequest)
+			return
+		}
+
+		if err := addTrickleICECandidates(session.PeerConnection, string(frag)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		whipSessionsMutex.Lock()
+		delete(sessions, resourceID)
+		whipSessionsMutex.Unlock()
+
+		if session.ViewerID != "" {
+			session.Stream.RemoveViewer(session.ViewerID)
+			if session.Forwarder != nil {
+				session.Forwarder.close()
+			}
+		} else {
+			session.Stream.ClearBroadcaster(session.Connection)
+		}
+
+		if err := session.PeerConnection.Close(); err != nil {
+			log.Printf("Error closing WHIP/WHEP resource %s: %v", resourceID, err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// addTrickleICECandidates parses the `a=candidate` lines out of a
+// trickle-ice-sdpfrag body and adds each one to the peer connection.
+func addTrickleICECandidates(pc *webrtc.PeerConnection, frag string) error {
+	for _, line := range strings.Split(frag, "\r\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		candidate := strings.TrimPrefix(line, "a=")
+		if err := pc.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckStreamKeyBearer(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		key    string
+		want   bool
+	}{
+		{"matching bearer token", "Bearer secret", "secret", true},
+		{"wrong token", "Bearer wrong", "secret", false},
+		{"missing header", "", "secret", false},
+		{"wrong auth scheme", "Basic secret", "secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/whip", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := checkStreamKeyBearer(r, tt.key); got != tt.want {
+				t.Errorf("checkStreamKeyBearer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddTrickleICECandidatesRejectsBadCandidate(t *testing.T) {
+	pc, err := createPeerConnection()
+	if err != nil {
+		t.Fatalf("createPeerConnection: %v", err)
+	}
+	defer pc.Close()
+
+	err = addTrickleICECandidates(pc, "a=candidate:not-a-real-candidate\r\n")
+	if err == nil {
+		t.Error("addTrickleICECandidates() error = nil for a malformed candidate line, want non-nil")
+	}
+}
+
+func TestAddTrickleICECandidatesIgnoresNonCandidateLines(t *testing.T) {
+	pc, err := createPeerConnection()
+	if err != nil {
+		t.Fatalf("createPeerConnection: %v", err)
+	}
+	defer pc.Close()
+
+	if err := addTrickleICECandidates(pc, "a=mid:0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n"); err != nil {
+		t.Errorf("addTrickleICECandidates() error = %v for a fragment with no candidate lines, want nil", err)
+	}
+}